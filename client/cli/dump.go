@@ -0,0 +1,114 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+// Package cli provides DumpCmd and LoadCmd, the dump/load subcommands
+// around client.DB.Export and client.DB.Import. The real cockroach
+// binary wires its full command tree (including the root command these
+// attach to, and its --host/--certs flags) in the server/storage-backed
+// parts of the repo that aren't part of this chunk; these two commands
+// are written to slot into that tree unmodified, via AddCommand, once
+// they are.
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cockroachdb/cockroach/client"
+)
+
+var (
+	dumpFormat    string
+	dumpStart     string
+	dumpEnd       string
+	loadFormat    string
+	loadBatchSize int
+	loadTxn       bool
+)
+
+// DumpCmd streams a key range from a cluster to stdout in the format
+// Export writes, for loading elsewhere with LoadCmd.
+var DumpCmd = &cobra.Command{
+	Use:   "dump <addr>",
+	Short: "dump a key range to stdout",
+	Long: `Dump streams every key in [--start, --end) from the cluster at addr to
+stdout, hex-encoded as CSV or NDJSON rows, for later loading into the
+same or another cluster with "cockroach load".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := client.Open(args[0])
+		if err != nil {
+			return err
+		}
+		format, err := parseFormat(dumpFormat)
+		if err != nil {
+			return err
+		}
+		return db.Export(os.Stdout, []byte(dumpStart), []byte(dumpEnd), format)
+	},
+}
+
+// LoadCmd reads rows written by DumpCmd from stdin and writes them back
+// with Put.
+var LoadCmd = &cobra.Command{
+	Use:   "load <addr>",
+	Short: "load rows dumped by \"cockroach dump\"",
+	Long: `Load reads rows in the format written by "cockroach dump" from stdin
+and writes them back to the cluster at addr with Put, batching
+--batch-size rows per call.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, err := client.Open(args[0])
+		if err != nil {
+			return err
+		}
+		format, err := parseFormat(loadFormat)
+		if err != nil {
+			return err
+		}
+		return db.Import(os.Stdin, format, loadBatchSize, loadTxn)
+	},
+}
+
+func init() {
+	DumpCmd.Flags().StringVar(&dumpFormat, "format", "csv", "output format: csv or ndjson")
+	DumpCmd.Flags().StringVar(&dumpStart, "start", "", "start key (inclusive)")
+	DumpCmd.Flags().StringVar(&dumpEnd, "end", "", "end key (exclusive)")
+
+	LoadCmd.Flags().StringVar(&loadFormat, "format", "csv", "input format: csv or ndjson")
+	LoadCmd.Flags().IntVar(&loadBatchSize, "batch-size", 1000, "rows to write per batch")
+	LoadCmd.Flags().BoolVar(&loadTxn, "txn", false, "wrap each batch in its own transaction")
+}
+
+// parseFormat maps the --format flag to a client.ExportFormat.
+func parseFormat(s string) (client.ExportFormat, error) {
+	switch s {
+	case "csv":
+		return client.CSV, nil
+	case "ndjson":
+		return client.NDJSON, nil
+	default:
+		return 0, &formatError{s}
+	}
+}
+
+type formatError struct{ format string }
+
+func (e *formatError) Error() string {
+	return "cli: unknown format " + e.format + `, expected "csv" or "ndjson"`
+}
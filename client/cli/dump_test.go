@@ -0,0 +1,49 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/client"
+)
+
+func TestParseFormat(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected client.ExportFormat
+	}{
+		{"csv", client.CSV},
+		{"ndjson", client.NDJSON},
+	}
+	for _, c := range testCases {
+		got, err := parseFormat(c.in)
+		if err != nil {
+			t.Fatalf("parseFormat(%q): %v", c.in, err)
+		}
+		if got != c.expected {
+			t.Errorf("parseFormat(%q): got %v, expected %v", c.in, got, c.expected)
+		}
+	}
+}
+
+func TestParseFormatUnknown(t *testing.T) {
+	if _, err := parseFormat("xml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
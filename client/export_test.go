@@ -0,0 +1,84 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadRowsCSV(t *testing.T) {
+	const data = "6161,3031\n6262,3032\n"
+	rows := make(chan exportRow, 2)
+	done := make(chan struct{})
+	defer close(done)
+	if err := readRows(strings.NewReader(data), CSV, rows, done); err != nil {
+		t.Fatal(err)
+	}
+	close(rows)
+	assertRows(t, rows, exportRow{"6161", "3031"}, exportRow{"6262", "3032"})
+}
+
+func TestReadRowsNDJSON(t *testing.T) {
+	const data = `{"key":"6161","value":"3031"}
+{"key":"6262","value":"3032"}
+`
+	rows := make(chan exportRow, 2)
+	done := make(chan struct{})
+	defer close(done)
+	if err := readRows(strings.NewReader(data), NDJSON, rows, done); err != nil {
+		t.Fatal(err)
+	}
+	close(rows)
+	assertRows(t, rows, exportRow{"6161", "3031"}, exportRow{"6262", "3032"})
+}
+
+// TestReadRowsStopsOnDone verifies that closing done unblocks a pending
+// send instead of leaking the reader when a caller (Import) stops
+// draining rows early.
+func TestReadRowsStopsOnDone(t *testing.T) {
+	const data = "6161,3031\n6262,3032\n6363,3033\n"
+	rows := make(chan exportRow) // unbuffered: the second send blocks until read
+	done := make(chan struct{})
+
+	errc := make(chan error, 1)
+	go func() { errc <- readRows(strings.NewReader(data), CSV, rows, done) }()
+
+	<-rows // drain exactly one row, then stop reading
+	close(done)
+
+	if err := <-errc; err != errImportStopped {
+		t.Fatalf("got err=%v, expected %v", err, errImportStopped)
+	}
+}
+
+func assertRows(t *testing.T, rows <-chan exportRow, expected ...exportRow) {
+	t.Helper()
+	var got []exportRow
+	for row := range rows {
+		got = append(got, row)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("got %d rows, expected %d: %v", len(got), len(expected), got)
+	}
+	for i, row := range got {
+		if row != expected[i] {
+			t.Errorf("row %d: got %+v, expected %+v", i, row, expected[i])
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package client
+
+import "sync"
+
+// Write describes a single key/value pair a transaction is about to
+// commit, as passed to an Invariant.
+type Write struct {
+	Key   []byte
+	Value []byte
+}
+
+// Invariant is a cheap, synchronous check run against a transaction's
+// write set immediately before it commits. It can reject the commit by
+// returning a non-nil error — e.g. the bank example's "sum of balances
+// is constant" rule, checked here instead of polled after the fact from
+// a separate goroutine.
+type Invariant func(writes []Write) error
+
+var (
+	invariantsMu sync.Mutex
+	invariants   = map[*Tx][]Invariant{}
+)
+
+// AddInvariant attaches fn to tx: CommitChecked will run it against tx's
+// write set before letting the commit through. Invariants accumulate
+// across multiple AddInvariant calls on the same tx and are discarded
+// once CommitChecked (or CommitWithInvariants) consumes them.
+//
+// This runs the check in this process, immediately before Commit is
+// called — it catches a violation the caller can already see, but it is
+// not the atomic, server-side guarantee the name might suggest: making
+// the check part of the EndTransaction command itself, so concurrent
+// transactions can't race past it, needs a hook in the storage layer
+// that this package doesn't have access to. See InvariantBatcher for the
+// closest approximation available at this layer: batching the checks
+// queued by concurrent transactions into a single pass.
+func (tx *Tx) AddInvariant(fn Invariant) {
+	invariantsMu.Lock()
+	defer invariantsMu.Unlock()
+	invariants[tx] = append(invariants[tx], fn)
+}
+
+// CommitChecked runs every invariant added to tx via AddInvariant against
+// writes, and, if all of them pass, commits b via tx. The invariants
+// registered on tx are consumed whether or not the commit is reached.
+func (tx *Tx) CommitChecked(b *Batch, writes []Write) error {
+	fns := drainInvariants(tx)
+	return checkAndCommit(writes, fns, func() error { return tx.Commit(b) })
+}
+
+// CommitWithInvariants runs extra (in addition to any already registered
+// on tx via AddInvariant) against writes and, if all of them pass,
+// commits b via tx. It's meant for read-modify-write transactions that
+// already have their write set in hand — the bank example's transfer
+// loop is the motivating case — without first having to call
+// AddInvariant for a one-off check. Like CommitChecked, any invariants
+// registered on tx are consumed whether or not the commit is reached.
+func CommitWithInvariants(tx *Tx, b *Batch, writes []Write, extra ...Invariant) error {
+	fns := append(drainInvariants(tx), extra...)
+	return checkAndCommit(writes, fns, func() error { return tx.Commit(b) })
+}
+
+// drainInvariants removes and returns the invariants registered on tx via
+// AddInvariant, leaving none behind for a later CommitChecked or
+// CommitWithInvariants call to pick up again.
+func drainInvariants(tx *Tx) []Invariant {
+	invariantsMu.Lock()
+	defer invariantsMu.Unlock()
+	fns := invariants[tx]
+	delete(invariants, tx)
+	return fns
+}
+
+// checkAndCommit runs every invariant against writes and, if all of them
+// pass, calls commit. It's factored out of CommitChecked and
+// CommitWithInvariants so the check/commit sequencing can be tested
+// without a live *Tx.
+func checkAndCommit(writes []Write, fns []Invariant, commit func() error) error {
+	for _, fn := range fns {
+		if err := fn(writes); err != nil {
+			return err
+		}
+	}
+	return commit()
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckAndCommitRejectsBeforeCommit(t *testing.T) {
+	errBroke := errors.New("invariant broken")
+	committed := false
+	commit := func() error { committed = true; return nil }
+
+	err := checkAndCommit([]Write{{Key: []byte("aa"), Value: []byte("1")}}, []Invariant{
+		func(writes []Write) error { return errBroke },
+	}, commit)
+	if err != errBroke {
+		t.Fatalf("got err=%v, expected %v", err, errBroke)
+	}
+	if committed {
+		t.Fatal("commit was called despite a failing invariant")
+	}
+}
+
+func TestCheckAndCommitCommitsOnSuccess(t *testing.T) {
+	var sum int
+	sumCheck := func(writes []Write) error {
+		for _, w := range writes {
+			sum += len(w.Value)
+		}
+		return nil
+	}
+	committed := false
+	commit := func() error { committed = true; return nil }
+
+	writes := []Write{{Key: []byte("aa"), Value: []byte("100")}, {Key: []byte("bb"), Value: []byte("22")}}
+	if err := checkAndCommit(writes, []Invariant{sumCheck}, commit); err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("commit was never called despite all invariants passing")
+	}
+	if sum != 5 {
+		t.Fatalf("got sum=%d, expected 5", sum)
+	}
+}
+
+func TestCheckAndCommitPropagatesCommitError(t *testing.T) {
+	errCommit := errors.New("commit failed")
+	err := checkAndCommit(nil, nil, func() error { return errCommit })
+	if err != errCommit {
+		t.Fatalf("got err=%v, expected %v", err, errCommit)
+	}
+}
+
+func TestAddInvariantAccumulatesAndDrains(t *testing.T) {
+	tx := &Tx{}
+	var calls int
+	tx.AddInvariant(func([]Write) error { calls++; return nil })
+	tx.AddInvariant(func([]Write) error { calls++; return nil })
+
+	fns := drainInvariants(tx)
+	if len(fns) != 2 {
+		t.Fatalf("got %d invariants registered, expected 2", len(fns))
+	}
+
+	if err := checkAndCommit(nil, fns, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("got calls=%d, expected 2", calls)
+	}
+
+	if fns := drainInvariants(tx); len(fns) != 0 {
+		t.Fatalf("got %d invariants left after drain, expected 0", len(fns))
+	}
+}
+
+func TestDrainInvariantsMergesWithExtra(t *testing.T) {
+	tx := &Tx{}
+	var registeredRan, extraRan bool
+	tx.AddInvariant(func([]Write) error { registeredRan = true; return nil })
+	extra := func([]Write) error { extraRan = true; return nil }
+
+	fns := append(drainInvariants(tx), extra)
+	if err := checkAndCommit(nil, fns, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !registeredRan {
+		t.Fatal("invariant registered via AddInvariant was never run")
+	}
+	if !extraRan {
+		t.Fatal("invariant passed directly was never run")
+	}
+
+	if fns := drainInvariants(tx); len(fns) != 0 {
+		t.Fatalf("got %d invariants left after drain, expected 0", len(fns))
+	}
+}
+
+func TestInvariantBatcherChecksQueuedInvariantsTogether(t *testing.T) {
+	b := NewInvariantBatcher(10 * time.Millisecond)
+
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- b.Submit(nil, func([]Write) error { return nil })
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInvariantBatcherPropagatesFailure(t *testing.T) {
+	b := NewInvariantBatcher(10 * time.Millisecond)
+	errBroke := errors.New("broken")
+	if err := b.Submit(nil, func([]Write) error { return errBroke }); err != errBroke {
+		t.Fatalf("got err=%v, expected %v", err, errBroke)
+	}
+}
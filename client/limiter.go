@@ -0,0 +1,282 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimit is returned by a Limiter in NonBlock mode when satisfying a
+// call would exceed the configured rate limits.
+var ErrLimit = errors.New("client: rate limit exceeded")
+
+// LimitMode controls what a Limiter does when its bucket is exhausted.
+type LimitMode int
+
+const (
+	// Block causes Run to sleep until capacity is available.
+	Block LimitMode = iota
+	// NonBlock causes Run to return ErrLimit immediately instead of
+	// sleeping.
+	NonBlock
+)
+
+const (
+	sampleInterval = time.Second
+	emaDecay       = 0.5
+	minSleep       = 10 * time.Millisecond
+)
+
+// rateMonitor tracks an exponential moving average of a quantity sampled
+// at fixed intervals, alongside the simple average over the monitor's
+// whole lifetime.
+type rateMonitor struct {
+	interval time.Duration
+	decay    float64
+
+	start   time.Time
+	last    time.Time
+	pending int64
+	total   int64
+	samples int64
+	ema     float64
+}
+
+func newRateMonitor(now time.Time) *rateMonitor {
+	return &rateMonitor{interval: sampleInterval, decay: emaDecay, start: now, last: now}
+}
+
+// add records n units (bytes or ops) observed at now.
+func (m *rateMonitor) add(n int64, now time.Time) {
+	m.pending += n
+	m.total += n
+	if elapsed := now.Sub(m.last); elapsed >= m.interval {
+		rate := float64(m.pending) / elapsed.Seconds()
+		if m.samples == 0 {
+			// Nothing to decay against yet; take the first sample as-is
+			// rather than blending it toward a meaningless zero.
+			m.ema = rate
+		} else {
+			m.ema = m.decay*rate + (1-m.decay)*m.ema
+		}
+		m.pending = 0
+		m.samples++
+		m.last = now
+	}
+}
+
+// status returns the current EMA rate, the lifetime average rate, and the
+// number of samples folded into the EMA.
+func (m *rateMonitor) status(now time.Time) (ema, avg float64, samples int64) {
+	if elapsed := now.Sub(m.start).Seconds(); elapsed > 0 {
+		avg = float64(m.total) / elapsed
+	}
+	return m.ema, avg, m.samples
+}
+
+// LimiterStatus is a point-in-time snapshot of a Limiter's rate usage, as
+// returned by Limiter.Status.
+type LimiterStatus struct {
+	BytesEMA       float64
+	BytesAvg       float64
+	BytesRemaining int64
+	OpsEMA         float64
+	OpsAvg         float64
+	OpsRemaining   int64
+	Samples        int64
+}
+
+// Limiter wraps a Runner (typically a *DB, or the *Tx handed to a
+// transactional closure) and throttles it to a configured transfer rate
+// and request rate. It implements Runner itself, so it's a drop-in
+// replacement anywhere a Runner is accepted — including the bank
+// example's thousand concurrent goroutines, which is exactly the kind of
+// load this is meant to cap.
+//
+// Limiter uses a token-bucket scheme: every Run withdraws one token from
+// an op bucket and the encoded size of the batch's keys and values from
+// a byte bucket, blocking (or, in NonBlock mode, returning ErrLimit) when
+// either bucket lacks capacity. Both buckets refill continuously at the
+// configured rate. Actual usage is sampled at sampleInterval into an
+// exponential moving average, reported via Status.
+type Limiter struct {
+	runner Runner
+
+	mu          sync.Mutex
+	mode        LimitMode
+	bytesPerSec float64
+	opsPerSec   float64
+	bytesBucket float64
+	opsBucket   float64
+	lastFill    time.Time
+
+	bytesRate *rateMonitor
+	opsRate   *rateMonitor
+}
+
+// NewLimiter returns a Limiter wrapping runner. It is unlimited until
+// SetLimit is called.
+func NewLimiter(runner Runner) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		runner:    runner,
+		lastFill:  now,
+		bytesRate: newRateMonitor(now),
+		opsRate:   newRateMonitor(now),
+	}
+}
+
+// SetLimit configures the transfer-rate (bytes/sec) and request-rate
+// (ops/sec) limits enforced by l; a limit of 0 leaves that dimension
+// unthrottled. mode selects whether Run blocks or returns ErrLimit when a
+// bucket is exhausted. SetLimit may be called at any time, concurrently
+// with Run.
+func (l *Limiter) SetLimit(bytesPerSec, opsPerSec float64, mode LimitMode) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bytesPerSec = bytesPerSec
+	l.opsPerSec = opsPerSec
+	l.mode = mode
+}
+
+// Status returns a snapshot of l's current rate usage.
+func (l *Limiter) Status() LimiterStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.fillLocked(now)
+	bEMA, bAvg, samples := l.bytesRate.status(now)
+	oEMA, oAvg, _ := l.opsRate.status(now)
+	return LimiterStatus{
+		BytesEMA:       bEMA,
+		BytesAvg:       bAvg,
+		BytesRemaining: int64(l.bytesBucket),
+		OpsEMA:         oEMA,
+		OpsAvg:         oAvg,
+		OpsRemaining:   int64(l.opsBucket),
+		Samples:        samples,
+	}
+}
+
+// fillLocked tops up both buckets for the time elapsed since the last
+// fill, capping each at one second's worth of its configured rate. l.mu
+// must be held.
+func (l *Limiter) fillLocked(now time.Time) {
+	elapsed := now.Sub(l.lastFill).Seconds()
+	l.lastFill = now
+	if elapsed <= 0 {
+		return
+	}
+	if l.bytesPerSec > 0 {
+		if l.bytesBucket += l.bytesPerSec * elapsed; l.bytesBucket > l.bytesPerSec {
+			l.bytesBucket = l.bytesPerSec
+		}
+	}
+	if l.opsPerSec > 0 {
+		if l.opsBucket += l.opsPerSec * elapsed; l.opsBucket > l.opsPerSec {
+			l.opsBucket = l.opsPerSec
+		}
+	}
+}
+
+// Run implements Runner. The byte cost of b is only known once it has
+// run, so Run gates on an estimate — the average bytes/op seen so far —
+// before dispatching, then corrects the byte bucket with the real size
+// afterward. A batch that turns out bigger than estimated simply drives
+// the bucket into debt, which delays (or, in NonBlock mode, rejects) the
+// next Run until it recovers; over a sustained stream of similarly-sized
+// batches, like the bank example's transfers, the estimate converges and
+// the byte limit is enforced the same way the op limit is.
+func (l *Limiter) Run(b *Batch) error {
+	if err := l.reserve(l.estimatedSize()); err != nil {
+		return err
+	}
+	err := l.runner.Run(b)
+	l.account(b)
+	return err
+}
+
+// estimatedSize returns the average bytes per op observed so far, or 0
+// before the first op has been accounted for.
+func (l *Limiter) estimatedSize() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opsRate.total == 0 {
+		return 0
+	}
+	return float64(l.bytesRate.total) / float64(l.opsRate.total)
+}
+
+// reserve blocks (or returns ErrLimit in NonBlock mode) until the op
+// bucket has at least one token and the byte bucket has at least
+// estimatedBytes, then withdraws the op token. The byte bucket itself is
+// withdrawn from in account, once the batch's real size is known.
+func (l *Limiter) reserve(estimatedBytes float64) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.fillLocked(now)
+		opReady := l.opsPerSec <= 0 || l.opsBucket >= 1
+		byteReady := l.bytesPerSec <= 0 || l.bytesBucket >= estimatedBytes
+		if opReady && byteReady {
+			if l.opsPerSec > 0 {
+				l.opsBucket--
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		mode := l.mode
+		l.mu.Unlock()
+		if mode == NonBlock {
+			return ErrLimit
+		}
+		time.Sleep(minSleep)
+	}
+}
+
+// account debits the byte bucket for b's actual encoded size and folds
+// the observation into both rate monitors.
+func (l *Limiter) account(b *Batch) {
+	size := batchSize(b)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.fillLocked(now)
+	if l.bytesPerSec > 0 {
+		l.bytesBucket -= float64(size)
+	}
+	l.bytesRate.add(size, now)
+	l.opsRate.add(1, now)
+}
+
+// batchSize returns the encoded size of the keys and values carried by
+// b's results: the same quantity a real request would have put on the
+// wire, whether read or written.
+func batchSize(b *Batch) int64 {
+	var size int64
+	for _, result := range b.Results {
+		for _, row := range result.Rows {
+			size += int64(len(row.Key))
+			size += int64(len(row.ValueBytes()))
+		}
+	}
+	return size
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateMonitor(t *testing.T) {
+	now := time.Now()
+	m := newRateMonitor(now)
+
+	// No sample yet: nothing to report but the lifetime average, which is
+	// zero with nothing added.
+	if ema, avg, samples := m.status(now); ema != 0 || avg != 0 || samples != 0 {
+		t.Fatalf("got ema=%v avg=%v samples=%d, expected all zero", ema, avg, samples)
+	}
+
+	// Add 1000 units over a one-second interval: the EMA should take on
+	// exactly that rate (nothing to decay against yet).
+	now = now.Add(time.Second)
+	m.add(1000, now)
+	if ema, _, samples := m.status(now); ema != 1000 || samples != 1 {
+		t.Fatalf("got ema=%v samples=%d, expected ema=1000 samples=1", ema, samples)
+	}
+
+	// A second, slower interval should pull the EMA toward the new rate
+	// without jumping straight to it.
+	now = now.Add(time.Second)
+	m.add(500, now)
+	if ema, _, samples := m.status(now); ema != 750 || samples != 2 {
+		t.Fatalf("got ema=%v samples=%d, expected ema=750 samples=2", ema, samples)
+	}
+}
+
+func TestLimiterFill(t *testing.T) {
+	l := NewLimiter(nil)
+	l.SetLimit(100, 10, Block)
+
+	now := l.lastFill
+	l.bytesBucket, l.opsBucket = 0, 0
+
+	now = now.Add(500 * time.Millisecond)
+	l.fillLocked(now)
+	if l.bytesBucket != 50 || l.opsBucket != 5 {
+		t.Fatalf("got bytesBucket=%v opsBucket=%v, expected 50 and 5", l.bytesBucket, l.opsBucket)
+	}
+
+	// Buckets cap at one second's worth of the configured rate.
+	now = now.Add(10 * time.Second)
+	l.fillLocked(now)
+	if l.bytesBucket != 100 || l.opsBucket != 10 {
+		t.Fatalf("got bytesBucket=%v opsBucket=%v, expected caps of 100 and 10", l.bytesBucket, l.opsBucket)
+	}
+}
+
+func TestLimiterReserveGatesOnBytes(t *testing.T) {
+	l := NewLimiter(nil)
+	l.SetLimit(100, 0, NonBlock)
+	l.bytesBucket = 50
+
+	// The op bucket is unthrottled (opsPerSec == 0); a request estimated
+	// at more bytes than are in the byte bucket must still be rejected.
+	if err := l.reserve(75); err != ErrLimit {
+		t.Fatalf("got err=%v, expected ErrLimit", err)
+	}
+	// An estimate the byte bucket can cover should be let through.
+	if err := l.reserve(50); err != nil {
+		t.Fatalf("got err=%v, expected nil", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package orm
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) { return json.Marshal(v) }
+func (jsonCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// JSON encodes values with encoding/json. It's the default Codec.
+var JSON Codec = jsonCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Gob encodes values with encoding/gob.
+var Gob Codec = gobCodec{}
+
+type protoCodec struct{}
+
+func (protoCodec) Encode(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errNotProtoMessage(v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Decode(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errNotProtoMessage(v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func errNotProtoMessage(v interface{}) error {
+	return fmt.Errorf("orm: Protobuf codec requires a proto.Message, got %T", v)
+}
+
+// Protobuf encodes values with Protocol Buffers; the registered type
+// must implement proto.Message.
+var Protobuf Codec = protoCodec{}
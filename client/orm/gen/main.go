@@ -0,0 +1,207 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+// Command orm-gen emits typed Get/Put accessors for a struct, so callers
+// on a hot path don't pay for orm.Get/orm.Put's registry lookup and
+// reflect-based Codec dispatch. A caller adds, next to its struct:
+//
+//	//go:generate orm-gen -type Account
+//
+// and gets a <file>_account_orm.go (named after the file holding the
+// go:generate line) with AccountGet/AccountPut that call
+// encoding/json directly on the concrete type — the orm-gen generator
+// only supports the JSON codec, since it has to pick one at generate
+// time rather than look Options.Codec up at runtime. If the struct has
+// `cockroach:"pk"` fields, the key is composed from them inline (a
+// fmt.Sprintf, not a loop over reflect.Value field lookups) and dropped
+// from the generated signature; otherwise the accessors take an id
+// string, used as the key directly.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeName = flag.String("type", "", "name of the struct to generate accessors for")
+	output   = flag.String("output", "", "output file name; default srcdir/<file>_<lowercased type>_orm.go")
+)
+
+func main() {
+	flag.Parse()
+	if *typeName == "" {
+		log.Fatal("orm-gen: -type is required")
+	}
+
+	gofile := os.Getenv("GOFILE")
+	if gofile == "" {
+		log.Fatal("orm-gen: must be run via go:generate (GOFILE unset)")
+	}
+	gopackage := os.Getenv("GOPACKAGE")
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, gofile, nil, 0)
+	if err != nil {
+		log.Fatalf("orm-gen: %v", err)
+	}
+
+	spec := findStruct(f, *typeName)
+	if spec == nil {
+		log.Fatalf("orm-gen: no struct named %s in %s", *typeName, gofile)
+	}
+	pks := pkFields(spec)
+
+	out, err := format.Source(generate(gopackage, *typeName, pks))
+	if err != nil {
+		log.Fatalf("orm-gen: %v", err)
+	}
+
+	outPath := *output
+	if outPath == "" {
+		outPath = strings.TrimSuffix(gofile, ".go") + "_" + strings.ToLower(*typeName) + "_orm.go"
+	}
+	if err := ioutil.WriteFile(outPath, out, 0644); err != nil {
+		log.Fatalf("orm-gen: %v", err)
+	}
+}
+
+// generate renders the accessors for typeName, declared in package
+// gopackage. When pks is non-empty, the key is composed from those
+// fields inline and dropped from the generated signatures; otherwise
+// the accessors take an id string used as the key directly.
+func generate(gopackage, typeName string, pks []string) []byte {
+	keyParam, keyExpr := "id", "id"
+	imports := []string{"encoding/json", "", "github.com/cockroachdb/cockroach/client"}
+	if len(pks) > 0 {
+		keyParam = ""
+		format := strings.Repeat("%v/", len(pks))
+		format = strings.TrimSuffix(format, "/")
+		fields := make([]string, len(pks))
+		for i, name := range pks {
+			fields[i] = "v." + name
+		}
+		keyExpr = fmt.Sprintf("fmt.Sprintf(%q, %s)", format, strings.Join(fields, ", "))
+		imports = []string{"encoding/json", "fmt", "", "github.com/cockroachdb/cockroach/client"}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by orm-gen -type %s; DO NOT EDIT.\n\n", typeName)
+	fmt.Fprintf(&buf, "package %s\n\n", gopackage)
+	buf.WriteString("import (\n")
+	for _, imp := range imports {
+		if imp == "" {
+			buf.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(&buf, "\t%q\n", imp)
+	}
+	buf.WriteString(")\n\n")
+
+	fmt.Fprintf(&buf, "// %sGet fetches and JSON-decodes the %s stored at its key.\n", typeName, typeName)
+	if keyParam != "" {
+		fmt.Fprintf(&buf, "func %sGet(runner client.Runner, %s string) (*%s, error) {\n", typeName, keyParam, typeName)
+		fmt.Fprintf(&buf, "\tout := &%s{}\n", typeName)
+	} else {
+		// v already carries the pk fields the key is composed from;
+		// decode the fetched value back into it and return it.
+		fmt.Fprintf(&buf, "func %sGet(runner client.Runner, v *%s) (*%s, error) {\n", typeName, typeName, typeName)
+		fmt.Fprintf(&buf, "\tout := v\n")
+	}
+	fmt.Fprintf(&buf, "\tb := &client.Batch{}\n\tb.Get(%s)\n", keyExpr)
+	fmt.Fprintf(&buf, "\tif err := runner.Run(b); err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(&buf, "\tif err := b.Results[0].Err; err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(&buf, "\tif err := json.Unmarshal(b.Results[0].Rows[0].ValueBytes(), out); err != nil {\n\t\treturn nil, err\n\t}\n")
+	fmt.Fprintf(&buf, "\treturn out, nil\n}\n\n")
+
+	fmt.Fprintf(&buf, "// %sPut JSON-encodes v and stores it at its key.\n", typeName)
+	if keyParam != "" {
+		fmt.Fprintf(&buf, "func %sPut(runner client.Runner, %s string, v *%s) error {\n", typeName, keyParam, typeName)
+	} else {
+		fmt.Fprintf(&buf, "func %sPut(runner client.Runner, v *%s) error {\n", typeName, typeName)
+	}
+	fmt.Fprintf(&buf, "\tdata, err := json.Marshal(v)\n\tif err != nil {\n\t\treturn err\n\t}\n")
+	fmt.Fprintf(&buf, "\tb := &client.Batch{}\n\tb.Put(%s, data)\n\treturn runner.Run(b)\n}\n", keyExpr)
+
+	return buf.Bytes()
+}
+
+// findStruct returns the *ast.StructType for the given type name declared
+// in f, or nil if there is no such struct.
+func findStruct(f *ast.File, name string) *ast.StructType {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts, ok := spec.(*ast.TypeSpec)
+			if !ok || ts.Name.Name != name {
+				continue
+			}
+			if st, ok := ts.Type.(*ast.StructType); ok {
+				return st
+			}
+		}
+	}
+	return nil
+}
+
+// pkFields returns the names of st's fields tagged `cockroach:"pk"`, in
+// declaration order.
+func pkFields(st *ast.StructType) []string {
+	var names []string
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		tagValue, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			continue
+		}
+		if !tagHas(reflect.StructTag(tagValue).Get("cockroach"), "pk") {
+			continue
+		}
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// tagHas reports whether tag (a comma-separated `cockroach:"..."` value)
+// contains part, mirroring orm.tagHas without importing the orm package
+// just for this.
+func tagHas(tag, part string) bool {
+	for _, p := range strings.Split(tag, ",") {
+		if strings.TrimSpace(p) == part {
+			return true
+		}
+	}
+	return false
+}
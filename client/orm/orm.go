@@ -0,0 +1,305 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+// Package orm is a thin, typed layer over client.DB for users who would
+// otherwise open-code the read-decode / encode-write pattern the bank
+// example uses for its Account struct. A registered type gets Get, Put,
+// Scan and Update helpers that marshal through a pluggable Codec and,
+// for Update, perform the same read-modify-write a hand-written
+// transaction would, but as a single call.
+//
+// Reflection does the encode/decode dispatch by default; go:generate a
+// type's accessors with the orm/gen command (see that package) to avoid
+// the reflection overhead in hot paths:
+//
+//	//go:generate go run github.com/cockroachdb/cockroach/client/orm/gen -type Account
+package orm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/client"
+)
+
+// Codec encodes and decodes a registered value to and from the bytes
+// stored in Cockroach.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// KeyFn derives the Cockroach key under which a value is stored, given
+// the id passed to Get/Put/Update. Most callers use the default, which
+// requires id to already be the full key; KeyFn exists so callers can
+// layer in a prefix or map a non-string id to a key (see Options.KeyFn).
+// A KeyFn sees only the id, never the value: composing the key from the
+// value's tagged primary-key fields is done by passing a nil id to Get or
+// Put instead (see Register).
+type KeyFn func(id interface{}) (string, error)
+
+// Options configures a type's registration. A zero Options uses JSON
+// encoding and treats the id passed to Get/Put/Update as the key
+// verbatim.
+type Options struct {
+	// KeyFn derives the storage key from the id argument. If nil, id is
+	// required to be a string and is used as-is.
+	KeyFn KeyFn
+	// Codec encodes and decodes values. If nil, JSON is used.
+	Codec Codec
+}
+
+// registration is what Register stores for a type.
+type registration struct {
+	opts   Options
+	pkTags []string // field names tagged `cockroach:"pk"`, in declaration order
+}
+
+var registry = map[reflect.Type]*registration{}
+
+// Register associates sample's type with opts so later Get, Put, Scan
+// and Update calls for that type know how to compute keys and encode
+// values. sample must be a pointer to a struct; only its type is used.
+// Fields tagged `cockroach:"pk"` let Get and Put be called with a nil
+// id: the key is then composed from those fields' values, in
+// declaration order, joined by "/" (see (*registration).composeKey).
+// The orm/gen code generator inlines the same composition into the
+// typed accessors it emits, when the type it's generating for has
+// tagged fields.
+func Register(sample interface{}, opts Options) error {
+	t := reflect.TypeOf(sample)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("orm: Register requires a pointer to a struct, got %T", sample)
+	}
+	if opts.Codec == nil {
+		opts.Codec = JSON
+	}
+	elem := t.Elem()
+	reg := &registration{opts: opts}
+	for i := 0; i < elem.NumField(); i++ {
+		if tag := elem.Field(i).Tag.Get("cockroach"); tagHas(tag, "pk") {
+			reg.pkTags = append(reg.pkTags, elem.Field(i).Name)
+		}
+	}
+	registry[elem] = reg
+	return nil
+}
+
+func tagHas(tag, part string) bool {
+	for _, p := range strings.Split(tag, ",") {
+		if strings.TrimSpace(p) == part {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the registration for dest's element type, which must
+// have been passed to Register.
+func lookup(dest interface{}) (*registration, reflect.Value, error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, reflect.Value{}, fmt.Errorf("orm: expected a pointer to a struct, got %T", dest)
+	}
+	reg, ok := registry[v.Elem().Type()]
+	if !ok {
+		return nil, reflect.Value{}, fmt.Errorf("orm: type %s was never registered", v.Elem().Type())
+	}
+	return reg, v.Elem(), nil
+}
+
+// key computes the storage key for id using reg's KeyFn, defaulting to
+// requiring id to be a string.
+func (reg *registration) key(id interface{}) (string, error) {
+	if reg.opts.KeyFn != nil {
+		return reg.opts.KeyFn(id)
+	}
+	s, ok := id.(string)
+	if !ok {
+		return "", fmt.Errorf("orm: id must be a string unless Options.KeyFn is set, got %T", id)
+	}
+	return s, nil
+}
+
+// resolveKey is like reg.key, except a nil id asks for the key to be
+// composed from v's `cockroach:"pk"` fields instead: the id argument to
+// Get and Put is optional whenever the registered type tags its own
+// primary-key fields.
+func (reg *registration) resolveKey(id interface{}, v reflect.Value) (string, error) {
+	if id != nil {
+		return reg.key(id)
+	}
+	return reg.composeKey(v)
+}
+
+// composeKey builds the storage key from v's `cockroach:"pk"`-tagged
+// fields, in declaration order, joined by "/". v must be the registered
+// struct value itself, not a pointer to it.
+func (reg *registration) composeKey(v reflect.Value) (string, error) {
+	if len(reg.pkTags) == 0 {
+		return "", fmt.Errorf(`orm: no id given and %s has no cockroach:"pk" fields`, v.Type())
+	}
+	parts := make([]string, len(reg.pkTags))
+	for i, name := range reg.pkTags {
+		parts[i] = fmt.Sprint(v.FieldByName(name).Interface())
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Get fetches the value stored at id into dest, whose type must have
+// been Registered. id may be nil if the registered type has
+// `cockroach:"pk"` fields, in which case the key is composed from
+// dest's own field values instead.
+func Get(runner client.Runner, id interface{}, dest interface{}) error {
+	reg, v, err := lookup(dest)
+	if err != nil {
+		return err
+	}
+	key, err := reg.resolveKey(id, v)
+	if err != nil {
+		return err
+	}
+	b := &client.Batch{}
+	b.Get(key)
+	if err := runner.Run(b); err != nil {
+		return err
+	}
+	if err := b.Results[0].Err; err != nil {
+		return err
+	}
+	return reg.opts.Codec.Decode(b.Results[0].Rows[0].ValueBytes(), dest)
+}
+
+// Put encodes src and writes it to the key for id. src's type must have
+// been Registered. id may be nil if the registered type has
+// `cockroach:"pk"` fields, in which case the key is composed from src's
+// own field values instead.
+func Put(runner client.Runner, id interface{}, src interface{}) error {
+	reg, v, err := lookup(src)
+	if err != nil {
+		return err
+	}
+	key, err := reg.resolveKey(id, v)
+	if err != nil {
+		return err
+	}
+	data, err := reg.opts.Codec.Encode(src)
+	if err != nil {
+		return err
+	}
+	b := &client.Batch{}
+	b.Put(key, data)
+	return runner.Run(b)
+}
+
+// Scan fetches the values with keys in [start, end) into destSlice,
+// which must be a pointer to a slice of the registered type. It issues a
+// single scan of at most 10000 rows; keys past the first 10000 in the
+// range are not returned.
+func Scan(runner client.Runner, start, end interface{}, destSlice interface{}) error {
+	sv := reflect.ValueOf(destSlice)
+	if sv.Kind() != reflect.Ptr || sv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("orm: Scan requires a pointer to a slice, got %T", destSlice)
+	}
+	elemType := sv.Elem().Type().Elem()
+	reg, ok := registry[elemType]
+	if !ok {
+		return fmt.Errorf("orm: type %s was never registered", elemType)
+	}
+	startKey, err := reg.key(start)
+	if err != nil {
+		return err
+	}
+	endKey, err := reg.key(end)
+	if err != nil {
+		return err
+	}
+
+	const scanChunk = 10000
+	b := &client.Batch{}
+	b.Scan(startKey, endKey, int64(scanChunk))
+	if err := runner.Run(b); err != nil {
+		return err
+	}
+	if err := b.Results[0].Err; err != nil {
+		return err
+	}
+
+	out := reflect.MakeSlice(sv.Elem().Type(), 0, len(b.Results[0].Rows))
+	for _, row := range b.Results[0].Rows {
+		ev := reflect.New(elemType)
+		if err := reg.opts.Codec.Decode(row.ValueBytes(), ev.Interface()); err != nil {
+			return err
+		}
+		out = reflect.Append(out, ev.Elem())
+	}
+	sv.Elem().Set(out)
+	return nil
+}
+
+// Update performs a read-modify-write of the value at id: it decodes the
+// current value, calls fn with a pointer to it, and writes the result
+// back with a conditional put against the previously-read bytes, so the
+// write fails if the value changed since it was read. This is the
+// read-modify-write the bank example's transfer loop open-codes by hand,
+// though that loop writes back with a plain Put. fn must be a
+// func(*T) error for the registered type T.
+func Update(runner client.Runner, id interface{}, fn interface{}) error {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func || fv.Type().NumIn() != 1 || fv.Type().In(0).Kind() != reflect.Ptr {
+		return fmt.Errorf("orm: Update requires a func(*T) error, got %T", fn)
+	}
+	elemType := fv.Type().In(0).Elem()
+	reg, ok := registry[elemType]
+	if !ok {
+		return fmt.Errorf("orm: type %s was never registered", elemType)
+	}
+	key, err := reg.key(id)
+	if err != nil {
+		return err
+	}
+
+	getBatch := &client.Batch{}
+	getBatch.Get(key)
+	if err := runner.Run(getBatch); err != nil {
+		return err
+	}
+	if err := getBatch.Results[0].Err; err != nil {
+		return err
+	}
+	prev := getBatch.Results[0].Rows[0].ValueBytes()
+
+	dest := reflect.New(elemType)
+	if len(prev) > 0 {
+		if err := reg.opts.Codec.Decode(prev, dest.Interface()); err != nil {
+			return err
+		}
+	}
+
+	if rets := fv.Call([]reflect.Value{dest}); !rets[0].IsNil() {
+		return rets[0].Interface().(error)
+	}
+
+	data, err := reg.opts.Codec.Encode(dest.Interface())
+	if err != nil {
+		return err
+	}
+	cb := &client.Batch{}
+	cb.CPut(key, data, prev)
+	return runner.Run(cb)
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package orm
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testAccount struct {
+	ID      string `cockroach:"pk"`
+	Balance int64
+}
+
+func TestRegisterPKTags(t *testing.T) {
+	if err := Register(&testAccount{}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	reg := registry[reflect.TypeOf(testAccount{})]
+	if len(reg.pkTags) != 1 || reg.pkTags[0] != "ID" {
+		t.Fatalf("got pkTags=%v, expected [ID]", reg.pkTags)
+	}
+}
+
+type testMultiPK struct {
+	Region string `cockroach:"pk"`
+	ID     int    `cockroach:"pk"`
+	Note   string
+}
+
+func TestComposeKey(t *testing.T) {
+	if err := Register(&testMultiPK{}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	reg := registry[reflect.TypeOf(testMultiPK{})]
+
+	v := reflect.ValueOf(testMultiPK{Region: "us-east", ID: 42, Note: "ignored"})
+	key, err := reg.composeKey(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "us-east/42" {
+		t.Fatalf("got key=%q, expected %q", key, "us-east/42")
+	}
+}
+
+func TestComposeKeyRequiresPKTags(t *testing.T) {
+	type noPK struct{ Name string }
+	if err := Register(&noPK{}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	reg := registry[reflect.TypeOf(noPK{})]
+	if _, err := reg.composeKey(reflect.ValueOf(noPK{Name: "x"})); err == nil {
+		t.Fatal("expected an error composing a key with no pk-tagged fields")
+	}
+}
+
+func TestTagHas(t *testing.T) {
+	testCases := []struct {
+		tag      string
+		part     string
+		expected bool
+	}{
+		{"pk", "pk", true},
+		{"pk,omitempty", "pk", true},
+		{"omitempty", "pk", false},
+		{"", "pk", false},
+	}
+	for _, c := range testCases {
+		if a := tagHas(c.tag, c.part); a != c.expected {
+			t.Errorf("tagHas(%q, %q): got %v, expected %v", c.tag, c.part, a, c.expected)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Vivek Menezes (vivek.menezes@gmail.com)
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Transfer moves amount of assetID from the account at fromKey to the
+// account at toKey. Both accounts are JSON-encoded map[string]int64
+// balances keyed by asset ID, a multi-coin generalization of a
+// single-balance account. The map is stored bare, not wrapped in a
+// Balances field the way the bank example's MultiCoinAccount is.
+//
+// Transfer runs the read/check/write cycle the bank example's transfer
+// loop open-codes — Get both accounts, check fromKey can cover amount,
+// write both back — inside a single transaction. Unlike that loop, which
+// writes with plain Puts, Transfer CPuts both accounts against the
+// values it read, and uses
+// CommitWithInvariants to reject the commit if the two CPuts it's about
+// to make don't conserve assetID's total balance across both accounts.
+// That invariant check runs in this process immediately before Commit,
+// the same as any other Invariant: it catches a bug in this function
+// that would otherwise corrupt both balances atomically, but it is not
+// a single atomic Raft command enforcing the invariant server-side —
+// that would need a TransferMultiCoin batch request handled by the
+// storage layer, which isn't part of this package. See AddInvariant's
+// doc comment for the same caveat in more detail.
+func (db *DB) Transfer(fromKey, toKey, assetID string, amount int64) error {
+	return db.Tx(func(tx *Tx) error {
+		get := &Batch{}
+		get.Get(fromKey, toKey)
+		if err := tx.Run(get); err != nil {
+			return err
+		}
+		if err := get.Results[0].Err; err != nil {
+			return err
+		}
+		fromPrev := get.Results[0].Rows[0].ValueBytes()
+		toPrev := get.Results[0].Rows[1].ValueBytes()
+
+		fromBalances, err := decodeBalances(fromPrev)
+		if err != nil {
+			return err
+		}
+		toBalances, err := decodeBalances(toPrev)
+		if err != nil {
+			return err
+		}
+		if fromBalances[assetID] < amount {
+			return fmt.Errorf("client: asset %s balance %d is less than transfer amount %d",
+				assetID, fromBalances[assetID], amount)
+		}
+		fromBalances[assetID] -= amount
+		toBalances[assetID] += amount
+
+		fromData, err := json.Marshal(fromBalances)
+		if err != nil {
+			return err
+		}
+		toData, err := json.Marshal(toBalances)
+		if err != nil {
+			return err
+		}
+
+		put := &Batch{}
+		put.CPut(fromKey, fromData, fromPrev).CPut(toKey, toData, toPrev)
+		writes := []Write{{Key: []byte(fromKey), Value: fromData}, {Key: []byte(toKey), Value: toData}}
+		return CommitWithInvariants(tx, put, writes, conservesBalance(assetID, fromKey, toKey, fromPrev, toPrev))
+	})
+}
+
+// conservesBalance returns an Invariant that rejects the commit if the
+// assetID balances it's about to write, decoded from writes, don't sum
+// to the same total as the assetID balances previously persisted at
+// fromKey and toKey (fromPrev, toPrev) — i.e. the transfer moved value
+// into or out of existence instead of just between the two accounts. It
+// decodes both sides fresh from their respective byte slices rather
+// than trusting the caller's in-memory balance maps, which by the time
+// an Invariant runs have already been mutated to the post-transfer
+// values.
+func conservesBalance(assetID, fromKey, toKey string, fromPrev, toPrev []byte) Invariant {
+	return func(writes []Write) error {
+		beforeFrom, err := decodeBalances(fromPrev)
+		if err != nil {
+			return err
+		}
+		beforeTo, err := decodeBalances(toPrev)
+		if err != nil {
+			return err
+		}
+		before := beforeFrom[assetID] + beforeTo[assetID]
+
+		afterFrom, err := writtenBalance(writes, fromKey, assetID)
+		if err != nil {
+			return err
+		}
+		afterTo, err := writtenBalance(writes, toKey, assetID)
+		if err != nil {
+			return err
+		}
+		after := afterFrom + afterTo
+
+		if after != before {
+			return fmt.Errorf("client: transfer of asset %s would change the total balance from %d to %d",
+				assetID, before, after)
+		}
+		return nil
+	}
+}
+
+// writtenBalance returns the assetID balance encoded in the write to
+// key among writes, or an error if writes has none.
+func writtenBalance(writes []Write, key, assetID string) (int64, error) {
+	for _, w := range writes {
+		if string(w.Key) == key {
+			balances, err := decodeBalances(w.Value)
+			if err != nil {
+				return 0, err
+			}
+			return balances[assetID], nil
+		}
+	}
+	return 0, fmt.Errorf("client: no write found for key %q", key)
+}
+
+// decodeBalances decodes a multi-coin account's asset-ID -> balance map,
+// treating a missing value (a key that's never been written) as an
+// empty account rather than an error.
+func decodeBalances(data []byte) (map[string]int64, error) {
+	balances := map[string]int64{}
+	if len(data) == 0 {
+		return balances, nil
+	}
+	if err := json.Unmarshal(data, &balances); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
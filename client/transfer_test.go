@@ -0,0 +1,73 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Vivek Menezes (vivek.menezes@gmail.com)
+
+package client
+
+import "testing"
+
+func TestConservesBalance(t *testing.T) {
+	fromPrev := []byte(`{"USD":100}`)
+	toPrev := []byte(`{"USD":100}`)
+	writes := []Write{
+		{Key: []byte("from"), Value: []byte(`{"USD":40}`)},
+		{Key: []byte("to"), Value: []byte(`{"USD":160}`)},
+	}
+	if err := conservesBalance("USD", "from", "to", fromPrev, toPrev)(writes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConservesBalanceCatchesDrift(t *testing.T) {
+	fromPrev := []byte(`{"USD":100}`)
+	toPrev := []byte(`{"USD":100}`)
+	writes := []Write{
+		{Key: []byte("from"), Value: []byte(`{"USD":40}`)},
+		{Key: []byte("to"), Value: []byte(`{"USD":170}`)}, // 10 materialized out of nowhere
+	}
+	if err := conservesBalance("USD", "from", "to", fromPrev, toPrev)(writes); err == nil {
+		t.Fatal("expected an error for a transfer that doesn't conserve the total balance")
+	}
+}
+
+func TestConservesBalanceJudgesWritesPassedIn(t *testing.T) {
+	// Regression test: the invariant must decode "after" from the write
+	// set it is handed at check time, not from in-memory maps captured
+	// when it was built.
+	fromPrev := []byte(`{"USD":100}`)
+	toPrev := []byte(`{"USD":100}`)
+	writes := []Write{
+		{Key: []byte("from"), Value: []byte(`{"USD":60}`)},
+		{Key: []byte("to"), Value: []byte(`{"USD":140}`)},
+	}
+	inv := conservesBalance("USD", "from", "to", fromPrev, toPrev)
+	if err := inv(writes); err != nil {
+		t.Fatal(err)
+	}
+	// Change the write set itself: an invariant that judged captured maps
+	// would still pass, while one that decodes writes sees the new total
+	// (999+140 != 200) and rejects it.
+	writes[0].Value = []byte(`{"USD":999}`)
+	if err := inv(writes); err == nil {
+		t.Fatal("expected the second check, against the mutated writes, to fail")
+	}
+}
+
+func TestWrittenBalanceMissingKey(t *testing.T) {
+	if _, err := writtenBalance(nil, "from", "USD"); err == nil {
+		t.Fatal("expected an error when writes has no entry for the key")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+// Package vault implements the HashiCorp Vault physical.Backend interface
+// on top of a CockroachDB client.DB, allowing Vault to use a Cockroach
+// cluster as its storage backend without going through SQL.
+package vault
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hashicorp/vault/physical"
+
+	"github.com/cockroachdb/cockroach/client"
+)
+
+// Backend stores Vault entries in CockroachDB, JSON-encoded and keyed by
+// their Vault path (the same encode-as-JSON-value approach used by the
+// bank example's Account type).
+type Backend struct {
+	db     *client.DB
+	prefix string
+}
+
+// New returns a Backend that reads and writes through db. Every Vault key
+// is stored under prefix, so a single Cockroach cluster can host several
+// independent Vault backends by giving each a distinct prefix.
+func New(db *client.DB, prefix string) *Backend {
+	return &Backend{db: db, prefix: prefix}
+}
+
+// key returns the Cockroach key under which the given Vault path is
+// stored.
+func (b *Backend) key(path string) string {
+	return b.prefix + path
+}
+
+// entryValue is the JSON envelope persisted for each Vault entry.
+type entryValue struct {
+	Value []byte `json:"value"`
+}
+
+// Put implements physical.Backend.
+func (b *Backend) Put(entry *physical.Entry) error {
+	data, err := json.Marshal(entryValue{Value: entry.Value})
+	if err != nil {
+		return err
+	}
+	return b.db.Run(b.db.B.Put(b.key(entry.Key), data))
+}
+
+// Get implements physical.Backend.
+func (b *Backend) Get(key string) (*physical.Entry, error) {
+	result, err := b.db.Get(b.key(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Rows) == 0 || result.Rows[0].ValueBytes() == nil {
+		return nil, nil
+	}
+	var v entryValue
+	if err := json.Unmarshal(result.Rows[0].ValueBytes(), &v); err != nil {
+		return nil, err
+	}
+	return &physical.Entry{Key: key, Value: v.Value}, nil
+}
+
+// Delete implements physical.Backend.
+func (b *Backend) Delete(key string) error {
+	_, err := b.db.Del(b.key(key))
+	return err
+}
+
+// List implements physical.Backend. It scans every key under prefix and
+// groups the results into immediate children, the way Vault expects:
+// "foo/bar" and "foo/baz" under prefix "foo/" both collapse to "bar" and
+// "baz", while "foo/bar/" (a further nesting) collapses to "bar/".
+func (b *Backend) List(prefix string) ([]string, error) {
+	base := b.key(prefix)
+	end := prefixEnd(base)
+	cursor := base
+
+	const scanChunk = 10000
+	collector := newChildCollector(base)
+	for {
+		result, err := b.db.Scan(cursor, end, scanChunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range result.Rows {
+			collector.add(row.Key)
+			cursor = string(row.Key) + "\x00"
+		}
+		if int64(len(result.Rows)) < scanChunk {
+			break
+		}
+	}
+	return collector.children, nil
+}
+
+// childCollector accumulates the deduplicated immediate children of base
+// across however many Scan pages List pages through. It's factored out
+// of List so the per-row trimming logic can be tested without a live
+// client.DB — the bug that let a stale cursor leak into the trim base
+// only showed up across multiple rows, which no db.Scan-free test could
+// exercise before this existed.
+type childCollector struct {
+	base     string
+	seen     map[string]struct{}
+	children []string
+}
+
+func newChildCollector(base string) *childCollector {
+	return &childCollector{base: base, seen: make(map[string]struct{})}
+}
+
+func (c *childCollector) add(key []byte) {
+	for _, child := range immediateChild(strings.TrimPrefix(string(key), c.base)) {
+		if _, ok := c.seen[child]; !ok {
+			c.seen[child] = struct{}{}
+			c.children = append(c.children, child)
+		}
+	}
+}
+
+// immediateChild collapses a path relative to a List prefix down to its
+// first path segment, keeping a trailing "/" to mark it as a directory,
+// matching physical.Backend's List contract. It returns no child for an
+// empty relative path (an exact match on the prefix itself).
+func immediateChild(rel string) []string {
+	if rel == "" {
+		return nil
+	}
+	if idx := strings.IndexByte(rel, '/'); idx >= 0 {
+		return []string{rel[:idx+1]}
+	}
+	return []string{rel}
+}
+
+// prefixEnd returns the lexicographically smallest key that sorts after
+// every key with the given prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for len(end) > 0 {
+		end[len(end)-1]++
+		if end[len(end)-1] != 0 {
+			break
+		}
+		end = end[:len(end)-1]
+	}
+	return string(end)
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+//
+// Author: Peter Mattis (peter.mattis@gmail.com)
+
+package vault
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImmediateChild(t *testing.T) {
+	testCases := []struct {
+		rel      string
+		expected []string
+	}{
+		{"", nil},
+		{"bar", []string{"bar"}},
+		{"bar/baz", []string{"bar/"}},
+		{"bar/baz/qux", []string{"bar/"}},
+	}
+	for _, c := range testCases {
+		if a, e := immediateChild(c.rel), c.expected; !reflect.DeepEqual(a, e) {
+			t.Errorf("immediateChild(%q): got %v, expected %v", c.rel, a, e)
+		}
+	}
+}
+
+func TestChildCollectorTrimsAgainstFixedBase(t *testing.T) {
+	// Regression test: base must stay fixed across rows, not get
+	// reassigned to the previous row's key like a Scan cursor would.
+	c := newChildCollector("foo/")
+	c.add([]byte("foo/bar"))
+	c.add([]byte("foo/baz"))
+	c.add([]byte("foo/baz/qux"))
+
+	expected := []string{"bar", "baz", "baz/"}
+	if !reflect.DeepEqual(c.children, expected) {
+		t.Fatalf("got %v, expected %v", c.children, expected)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	testCases := []struct {
+		prefix   string
+		expected string
+	}{
+		{"a", "b"},
+		{"ab", "ac"},
+		{"a\xff", "b"},
+	}
+	for _, c := range testCases {
+		if a, e := prefixEnd(c.prefix), c.expected; a != e {
+			t.Errorf("prefixEnd(%q): got %q, expected %q", c.prefix, a, e)
+		}
+	}
+}
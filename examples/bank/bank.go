@@ -34,12 +34,18 @@ import (
 )
 
 var useTransaction = flag.Bool("use-transaction", true, "Turn off to disable transaction.")
+var numAssets = flag.Int("num-assets", 10, "Number of distinct asset IDs to transfer between accounts.")
 
 // Makes an id string from an id int.
 func makeAccountID(id int) []byte {
 	return []byte(fmt.Sprintf("%09d", id))
 }
 
+// Makes an asset ID string from an asset index.
+func makeAssetID(asset int) string {
+	return fmt.Sprintf("asset-%04d", asset)
+}
+
 // Bank stores all the bank related state.
 type Bank struct {
 	db           *client.DB
@@ -47,21 +53,25 @@ type Bank struct {
 	numTransfers int32
 }
 
-type Account struct {
-	Balance int64
+// MultiCoinAccount holds a balance per asset ID, generalizing the
+// original single-currency Account.
+type MultiCoinAccount struct {
+	Balances map[string]int64
 }
 
-func (a Account) encode() ([]byte, error) {
+func (a MultiCoinAccount) encode() ([]byte, error) {
 	return json.Marshal(a)
 }
 
-func (a *Account) decode(b []byte) error {
+func (a *MultiCoinAccount) decode(b []byte) error {
+	a.Balances = nil
 	return json.Unmarshal(b, a)
 }
 
-// Read the balances in all the accounts and return them.
-func (bank *Bank) sumAllAccounts() int64 {
-	var result int64
+// sumAllAccounts reads every account and returns the total balance of
+// each asset, keyed by asset ID.
+func (bank *Bank) sumAllAccounts() map[string]int64 {
+	result := make(map[string]int64)
 	err := bank.db.Tx(func(tx *client.Tx) error {
 		scan := tx.Scan(makeAccountID(0), makeAccountID(bank.numAccounts), int64(bank.numAccounts))
 		if scan.Err != nil {
@@ -72,13 +82,14 @@ func (bank *Bank) sumAllAccounts() int64 {
 		}
 		// Copy responses into balances.
 		for i := 0; i < bank.numAccounts; i++ {
-			account := &Account{}
+			account := &MultiCoinAccount{}
 			err := account.decode(scan.Rows[i].ValueBytes())
 			if err != nil {
 				log.Fatal(err)
 			}
-			// fmt.Printf("Account %d contains %d$\n", i, account.Balance)
-			result += account.Balance
+			for assetID, balance := range account.Balances {
+				result[assetID] += balance
+			}
 		}
 		return nil
 	})
@@ -88,8 +99,11 @@ func (bank *Bank) sumAllAccounts() int64 {
 	return result
 }
 
-// continuouslyTransferMoney() keeps moving random amounts between
-// random accounts.
+// continuousMoneyTransfer keeps moving random amounts of a random asset
+// between random accounts. All of an account's asset balances live in its
+// single key, so transfers of different assets between the same accounts
+// still read and write the same keys; raising *numAssets does not reduce
+// contention between transfers.
 func (bank *Bank) continuousMoneyTransfer() {
 	for {
 		from := makeAccountID(rand.Intn(bank.numAccounts))
@@ -98,8 +112,10 @@ func (bank *Bank) continuousMoneyTransfer() {
 		if bytes.Equal(from, to) {
 			continue
 		}
+		assetID := makeAssetID(rand.Intn(*numAssets))
 		exchangeAmount := rand.Int63n(100)
-		// transferMoney transfers exchangeAmount between the two accounts
+		// transferMoney transfers exchangeAmount of assetID between the
+		// two accounts.
 		transferMoney := func(runner client.Runner) error {
 			batchRead := &client.Batch{}
 			batchRead.Get(from, to)
@@ -110,31 +126,31 @@ func (bank *Bank) continuousMoneyTransfer() {
 				return batchRead.Results[0].Err
 			}
 			// Read from value.
-			fromAccount := &Account{}
+			fromAccount := &MultiCoinAccount{}
 			err := fromAccount.decode(batchRead.Results[0].Rows[0].ValueBytes())
 			if err != nil {
 				return err
 			}
-			// Ensure there is enough cash.
-			if fromAccount.Balance < exchangeAmount {
+			// Ensure there is enough cash of this asset.
+			if fromAccount.Balances[assetID] < exchangeAmount {
 				return nil
 			}
 			// Read to value.
-			toAccount := &Account{}
+			toAccount := &MultiCoinAccount{}
 			errRead := toAccount.decode(batchRead.Results[0].Rows[1].ValueBytes())
 			if errRead != nil {
 				return errRead
 			}
 			// Update both accounts.
 			batchWrite := &client.Batch{}
-			fromAccount.Balance -= exchangeAmount
-			toAccount.Balance += exchangeAmount
+			fromAccount.Balances[assetID] -= exchangeAmount
+			toAccount.Balances[assetID] += exchangeAmount
 			if fromValue, err := fromAccount.encode(); err != nil {
 				return err
 			} else if toValue, err := toAccount.encode(); err != nil {
 				return err
 			} else {
-				batchWrite.Put(fromValue, toValue)
+				batchWrite.Put(from, fromValue).Put(to, toValue)
 			}
 			return runner.Run(batchWrite)
 		}
@@ -149,14 +165,18 @@ func (bank *Bank) continuousMoneyTransfer() {
 	}
 }
 
-// Initialize all the bank accounts with cash.
+// Initialize all the bank accounts with cash in every asset.
 func (bank *Bank) initBankAccounts(cash int64) {
-	batch := &client.Batch{}
-	account := Account{Balance: cash}
+	balances := make(map[string]int64, *numAssets)
+	for asset := 0; asset < *numAssets; asset++ {
+		balances[makeAssetID(asset)] = cash
+	}
+	account := MultiCoinAccount{Balances: balances}
 	value, err := account.encode()
 	if err != nil {
 		log.Fatal(err)
 	}
+	batch := &client.Batch{}
 	for i := 0; i < bank.numAccounts; i++ {
 		batch = batch.Put(makeAccountID(i), value)
 	}
@@ -171,11 +191,14 @@ func (bank *Bank) periodicallyCheckBalances(initCash int64) {
 		// Sleep for a bit to allow money transfers to happen in the background.
 		time.Sleep(time.Second)
 		fmt.Printf("%d transfers were executed.\n\n", bank.numTransfers)
-		// Check that all the money is accounted for.
-		totalAmount := bank.sumAllAccounts()
-		if totalAmount != int64(bank.numAccounts)*initCash {
-			err := fmt.Sprintf("\nTotal cash in the bank = %d.\n", totalAmount)
-			log.Fatal(err)
+		// Check that all the money is accounted for, asset by asset.
+		totals := bank.sumAllAccounts()
+		for asset := 0; asset < *numAssets; asset++ {
+			assetID := makeAssetID(asset)
+			if totals[assetID] != int64(bank.numAccounts)*initCash {
+				err := fmt.Sprintf("\nTotal cash of asset %s in the bank = %d.\n", assetID, totals[assetID])
+				log.Fatal(err)
+			}
 		}
 		fmt.Printf("\nThe bank is in good order\n\n")
 	}